@@ -1,57 +1,479 @@
 package function
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v2"
 )
 
 // ConfigFile is the name of the config's serialized form.
 const ConfigFile = "func.yaml"
 
+// configFormatEnvVar overrides the auto-detection of the config file's
+// encoding when no func.yaml/func.json/func.toml yet exists in the
+// project root (for example, the first time a Function is written).
+const configFormatEnvVar = "FUNC_CONFIG_FORMAT"
+
+// configFilenames maps each supported encoding to the filename func looks
+// for (and writes) in a project's root.
+var configFilenames = map[string]string{
+	"yaml": "func.yaml",
+	"json": "func.json",
+	"toml": "func.toml",
+}
+
+// codecs maps each supported encoding to its codec implementation.
+var codecs = map[string]codec{
+	"yaml": yamlCodec{},
+	"json": jsonCodec{},
+	"toml": tomlCodec{},
+}
+
+// codec encodes and decodes a config to and from one on-disk format.
+// Implementations wrap a third-party marshalling library so that
+// newConfig/writeConfig can treat func.yaml, func.json and func.toml
+// interchangeably.
+type codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	UnmarshalStrict(data []byte, v interface{}) error
+	// FormatError rewrites a raw error from UnmarshalStrict into the
+	// user-facing form used by newConfig's validation messages, stripping
+	// the underlying library's own error prefix.
+	FormatError(err error) string
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(v interface{}) ([]byte, error) { return yaml.Marshal(v) }
+func (yamlCodec) Unmarshal(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+func (yamlCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	return yaml.UnmarshalStrict(data, v)
+}
+func (yamlCodec) FormatError(err error) string {
+	msg := err.Error()
+	reg := regexp.MustCompile("not found in type .*")
+	if strings.HasPrefix(msg, "yaml: unmarshal errors:") {
+		msg = reg.ReplaceAllString(msg, "is not valid")
+		msg = strings.Replace(msg, "yaml: unmarshal errors:\n", "", 1)
+	} else if strings.HasPrefix(msg, "yaml:") {
+		msg = reg.ReplaceAllString(msg, "is not valid")
+		msg = strings.Replace(msg, "yaml: ", "  ", 1)
+	}
+	return msg
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+func (jsonCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+func (jsonCodec) FormatError(err error) string {
+	msg := err.Error()
+	reg := regexp.MustCompile(`unknown field "(.*)"`)
+	msg = strings.TrimPrefix(msg, "json: ")
+	return reg.ReplaceAllString(msg, `field $1 is not valid`)
+}
+
+type tomlCodec struct{}
+
+// tomlConfigShape mirrors config's on-disk shape for TOML, except that
+// Envs is []tomlEnvShape rather than Envs: BurntSushi/toml has no
+// equivalent of yaml.Unmarshaler/json.Unmarshaler for union-typed
+// fields, so decoding the scalar-or-list `value` field has to go through
+// an interface{}-typed intermediate, same as envAlias does for YAML/JSON.
+type tomlConfigShape struct {
+	Version     string            `toml:"version"`
+	Name        string            `toml:"name"`
+	Namespace   string            `toml:"namespace"`
+	Runtime     string            `toml:"runtime"`
+	Image       string            `toml:"image"`
+	ImageDigest string            `toml:"imageDigest"`
+	Trigger     string            `toml:"trigger"`
+	Builder     string            `toml:"builder"`
+	BuilderMap  map[string]string `toml:"builderMap"`
+	Volumes     Volumes           `toml:"volumes"`
+	Envs        []tomlEnvShape    `toml:"envs"`
+	Annotations map[string]string `toml:"annotations"`
+}
+
+type tomlEnvShape struct {
+	Name  *string     `toml:"name"`
+	Value interface{} `toml:"value"`
+}
+
+func configToTomlShape(c *config) tomlConfigShape {
+	shape := tomlConfigShape{
+		Version:     c.Version,
+		Name:        c.Name,
+		Namespace:   c.Namespace,
+		Runtime:     c.Runtime,
+		Image:       c.Image,
+		ImageDigest: c.ImageDigest,
+		Trigger:     c.Trigger,
+		Builder:     c.Builder,
+		BuilderMap:  c.BuilderMap,
+		Volumes:     c.Volumes,
+		Annotations: c.Annotations,
+	}
+	shape.Envs = make([]tomlEnvShape, len(c.Envs))
+	for i, e := range c.Envs {
+		es := tomlEnvShape{Name: e.Name}
+		switch values := e.values(); len(values) {
+		case 0:
+			es.Value = nil
+		case 1:
+			es.Value = values[0]
+		default:
+			es.Value = values
+		}
+		shape.Envs[i] = es
+	}
+	return shape
+}
+
+func tomlShapeToConfig(shape tomlConfigShape, c *config) error {
+	c.Version = shape.Version
+	c.Name = shape.Name
+	c.Namespace = shape.Namespace
+	c.Runtime = shape.Runtime
+	c.Image = shape.Image
+	c.ImageDigest = shape.ImageDigest
+	c.Trigger = shape.Trigger
+	c.Builder = shape.Builder
+	c.BuilderMap = shape.BuilderMap
+	c.Volumes = shape.Volumes
+	c.Annotations = shape.Annotations
+
+	c.Envs = make(Envs, len(shape.Envs))
+	for i, es := range shape.Envs {
+		values, err := envValuesFromAlias(es.Value)
+		if err != nil {
+			return fmt.Errorf("envs entry #%d: %w", i, err)
+		}
+		env := Env{Name: es.Name, Values: values}
+		if len(values) > 0 {
+			env.Value = &values[0]
+		}
+		c.Envs[i] = env
+	}
+	return nil
+}
+
+func (tomlCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	if c, ok := v.(*config); ok {
+		err = toml.NewEncoder(buf).Encode(configToTomlShape(c))
+	} else {
+		err = toml.NewEncoder(buf).Encode(v)
+	}
+	return buf.Bytes(), err
+}
+func (tomlCodec) Unmarshal(data []byte, v interface{}) error {
+	if c, ok := v.(*config); ok {
+		var shape tomlConfigShape
+		if _, err := toml.Decode(string(data), &shape); err != nil {
+			return err
+		}
+		return tomlShapeToConfig(shape, c)
+	}
+	_, err := toml.Decode(string(data), v)
+	return err
+}
+func (tomlCodec) UnmarshalStrict(data []byte, v interface{}) error {
+	if c, ok := v.(*config); ok {
+		var shape tomlConfigShape
+		md, err := toml.Decode(string(data), &shape)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("field '%s' is not valid", undecoded[0])
+		}
+		return tomlShapeToConfig(shape, c)
+	}
+	md, err := toml.Decode(string(data), v)
+	if err != nil {
+		return err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return fmt.Errorf("field '%s' is not valid", undecoded[0])
+	}
+	return nil
+}
+func (tomlCodec) FormatError(err error) string { return err.Error() }
+
+// resolveConfigFile returns the path to and encoding of the config file
+// that should be used for the Function rooted at root: whichever of
+// func.yaml, func.json or func.toml already exists there, or, if none do
+// yet, the format named by FUNC_CONFIG_FORMAT (default "yaml").
+func resolveConfigFile(root string) (filename, format string) {
+	for _, format := range []string{"yaml", "json", "toml"} {
+		candidate := filepath.Join(root, configFilenames[format])
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, format
+		}
+	}
+	format = os.Getenv(configFormatEnvVar)
+	if _, ok := configFilenames[format]; !ok {
+		format = "yaml"
+	}
+	return filepath.Join(root, configFilenames[format]), format
+}
+
+// CurrentConfigVersion is the latest config.Version known to this version
+// of func.  Config files written at a prior version are migrated forward
+// to this version on load.
+const CurrentConfigVersion = "1.0"
+
+// EmptyDirOptions configures a node-local scratch Volume.  An empty
+// EmptyDirOptions (`emptyDir: {}`) is valid and selects the node's
+// default medium with no size limit.
+type EmptyDirOptions struct {
+	Medium    *string `yaml:"medium,omitempty" json:"medium,omitempty" toml:"medium,omitempty"`
+	SizeLimit *string `yaml:"sizeLimit,omitempty" json:"sizeLimit,omitempty" toml:"sizeLimit,omitempty"`
+}
+
+// KeyToPath projects a single key of a Secret or ConfigMap Volume source
+// to a specific file Path within the mount, optionally with its own file
+// Mode.
+type KeyToPath struct {
+	Key  string `yaml:"key" json:"key" toml:"key"`
+	Path string `yaml:"path" json:"path" toml:"path"`
+	Mode *int32 `yaml:"mode,omitempty" json:"mode,omitempty" toml:"mode,omitempty"`
+}
+
 type Volumes []Volume
 type Volume struct {
-	Secret    *string `yaml:"secret,omitempty"`
-	ConfigMap *string `yaml:"configMap,omitempty"`
-	Path      *string `yaml:"path"`
+	Secret                *string          `yaml:"secret,omitempty" json:"secret,omitempty" toml:"secret,omitempty"`
+	ConfigMap             *string          `yaml:"configMap,omitempty" json:"configMap,omitempty" toml:"configMap,omitempty"`
+	PersistentVolumeClaim *string          `yaml:"persistentVolumeClaim,omitempty" json:"persistentVolumeClaim,omitempty" toml:"persistentVolumeClaim,omitempty"`
+	EmptyDir              *EmptyDirOptions `yaml:"emptyDir,omitempty" json:"emptyDir,omitempty" toml:"emptyDir,omitempty"`
+	Path                  *string          `yaml:"path" json:"path" toml:"path"`
+	ReadOnly              *bool            `yaml:"readOnly,omitempty" json:"readOnly,omitempty" toml:"readOnly,omitempty"`
+	SubPath               *string          `yaml:"subPath,omitempty" json:"subPath,omitempty" toml:"subPath,omitempty"`
+	// Items projects individual keys of a Secret/ConfigMap source to
+	// specific file paths, instead of mounting every key.  Not applicable
+	// to PersistentVolumeClaim or EmptyDir sources.
+	Items []KeyToPath `yaml:"items,omitempty" json:"items,omitempty" toml:"items,omitempty"`
 }
 
 type Envs []Env
+
+// Env is a single entry in a Function's `envs` list.  Its `value` field
+// accepts either a single scalar source, e.g. `value: {{ env.MY_ENV }}`,
+// or an ordered list of fallback sources tried until the first resolves,
+// e.g. `value: ["{{ env.PRIMARY }}", "{{ env.FALLBACK }}", "literal-default"]`.
+// Value holds the scalar form (or the first entry of a list form, for
+// callers not yet updated to read Values) and Values always holds the
+// normalized list form; use Values rather than Value when resolving.
+// Value/Values are tagged toml:"-": BurntSushi/toml has no union-type
+// decode hook, so tomlCodec decodes/encodes Envs through tomlConfigShape
+// instead of Env's own struct tags.
 type Env struct {
-	Name  *string `yaml:"name,omitempty"`
-	Value *string `yaml:"value"`
+	Name   *string  `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty"`
+	Value  *string  `yaml:"value,omitempty" json:"value,omitempty" toml:"-"`
+	Values []string `yaml:"-" json:"-" toml:"-"`
+}
+
+// envAlias mirrors Env's on-disk shape but with an untyped Value, so that
+// UnmarshalYAML/UnmarshalJSON can detect whether `value` was given as a
+// scalar or a list before normalizing it into Env.Values.
+type envAlias struct {
+	Name  *string     `yaml:"name,omitempty" json:"name,omitempty"`
+	Value interface{} `yaml:"value,omitempty" json:"value,omitempty"`
+}
+
+func envValuesFromAlias(raw interface{}) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("value entry #%d is not a string", i)
+			}
+			values[i] = s
+		}
+		return values, nil
+	case []string:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("value field must be a string or a list of strings")
+	}
+}
+
+// UnmarshalYAML decodes an Env's `value` field, accepting either the
+// scalar form or the ordered-fallback-list form.
+func (e *Env) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var a envAlias
+	if err := unmarshal(&a); err != nil {
+		return err
+	}
+	values, err := envValuesFromAlias(a.Value)
+	if err != nil {
+		return err
+	}
+	e.Name = a.Name
+	e.Values = values
+	if len(values) > 0 {
+		e.Value = &values[0]
+	}
+	return nil
+}
+
+// MarshalYAML re-serializes Env, writing `value` back out as a scalar
+// when there is a single source and as a list when there are several.
+// It reads e.values() rather than e.Values directly so that an Env built
+// with only the legacy Value field set (not yet normalized into Values)
+// still round-trips.
+func (e Env) MarshalYAML() (interface{}, error) {
+	a := envAlias{Name: e.Name}
+	switch values := e.values(); len(values) {
+	case 0:
+		a.Value = nil
+	case 1:
+		a.Value = values[0]
+	default:
+		a.Value = values
+	}
+	return a, nil
+}
+
+// UnmarshalJSON mirrors UnmarshalYAML for the JSON encoding.
+func (e *Env) UnmarshalJSON(data []byte) error {
+	var a envAlias
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	values, err := envValuesFromAlias(a.Value)
+	if err != nil {
+		return err
+	}
+	e.Name = a.Name
+	e.Values = values
+	if len(values) > 0 {
+		e.Value = &values[0]
+	}
+	return nil
+}
+
+// MarshalJSON mirrors MarshalYAML for the JSON encoding.
+func (e Env) MarshalJSON() ([]byte, error) {
+	a := envAlias{Name: e.Name}
+	switch values := e.values(); len(values) {
+	case 0:
+		a.Value = nil
+	case 1:
+		a.Value = values[0]
+	default:
+		a.Value = values
+	}
+	return json.Marshal(a)
 }
 
 // Config represents the serialized state of a Function's metadata.
 // See the Function struct for attribute documentation.
 type config struct {
-	Name        string            `yaml:"name"`
-	Namespace   string            `yaml:"namespace"`
-	Runtime     string            `yaml:"runtime"`
-	Image       string            `yaml:"image"`
-	ImageDigest string            `yaml:"imageDigest"`
-	Trigger     string            `yaml:"trigger"`
-	Builder     string            `yaml:"builder"`
-	BuilderMap  map[string]string `yaml:"builderMap"`
-	Volumes     Volumes           `yaml:"volumes"`
-	Envs        Envs              `yaml:"envs"`
-	Annotations map[string]string `yaml:"annotations"`
+	Version     string            `yaml:"version" json:"version" toml:"version"`
+	Name        string            `yaml:"name" json:"name" toml:"name"`
+	Namespace   string            `yaml:"namespace" json:"namespace" toml:"namespace"`
+	Runtime     string            `yaml:"runtime" json:"runtime" toml:"runtime"`
+	Image       string            `yaml:"image" json:"image" toml:"image"`
+	ImageDigest string            `yaml:"imageDigest" json:"imageDigest" toml:"imageDigest"`
+	Trigger     string            `yaml:"trigger" json:"trigger" toml:"trigger"`
+	Builder     string            `yaml:"builder" json:"builder" toml:"builder"`
+	BuilderMap  map[string]string `yaml:"builderMap" json:"builderMap" toml:"builderMap"`
+	Volumes     Volumes           `yaml:"volumes" json:"volumes" toml:"volumes"`
+	Envs        Envs              `yaml:"envs" json:"envs" toml:"envs"`
+	Annotations map[string]string `yaml:"annotations" json:"annotations" toml:"annotations"`
 	// Add new values to the toConfig/fromConfig functions.
 }
 
+// migration mutates a decoded func.yaml (as a generic map, so that fields
+// unknown to the current version are not lost) from one version to the
+// next.  Migrations are applied sequentially, so each only needs to know
+// how to get from its "from" version to the very next version.
+type migration func(map[string]interface{})
+
+// migrations is the set of migrations necessary to bring a func.yaml from
+// its on-disk version up to CurrentConfigVersion, keyed "from->to".
+// New migrations are added here as the config schema evolves; existing
+// entries must not be changed once released.
+var migrations = map[string]migration{
+	"0.0->1.0": func(m map[string]interface{}) {
+		m["version"] = "1.0"
+	},
+}
+
+// migrate applies all applicable migrations to raw, in order, until its
+// version reaches CurrentConfigVersion.  raw is mutated in place.
+func migrate(raw map[string]interface{}) error {
+	version, _ := raw["version"].(string)
+	if version == "" {
+		version = "0.0"
+	}
+	for version != CurrentConfigVersion {
+		key := fmt.Sprintf("%s->%s", version, nextVersion(version))
+		m, ok := migrations[key]
+		if !ok {
+			return fmt.Errorf("no migration path from config version '%s' to '%s'", version, CurrentConfigVersion)
+		}
+		m(raw)
+		next, _ := raw["version"].(string)
+		if next == version {
+			return fmt.Errorf("migration '%s' did not update the config version", key)
+		}
+		version = next
+	}
+	return nil
+}
+
+// nextVersion returns the version a "from" version migrates to, by looking
+// it up among the registered migrations' keys.
+func nextVersion(from string) string {
+	prefix := from + "->"
+	for key := range migrations {
+		if strings.HasPrefix(key, prefix) {
+			return strings.TrimPrefix(key, prefix)
+		}
+	}
+	return CurrentConfigVersion
+}
+
 // newConfig returns a Config populated from data serialized to disk if it is
 // available.  Errors are returned if the path is not valid, if there are
 // errors accessing an extant config file, or the contents of the file do not
 // unmarshall.  A missing file at a valid path does not error but returns the
-// empty value of Config.
+// empty value of Config.  Config files written by an older version of func
+// are migrated in place to the current version before being decoded.
 func newConfig(root string) (c config, err error) {
-	filename := filepath.Join(root, ConfigFile)
+	filename, format := resolveConfigFile(root)
 	if _, err = os.Stat(filename); err != nil {
 		// do not consider a missing config file an error.  Just return.
 		if os.IsNotExist(err) {
@@ -59,29 +481,46 @@ func newConfig(root string) (c config, err error) {
 		}
 		return
 	}
+	cdc := codecs[format]
 	bb, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return
 	}
 
+	var raw map[string]interface{}
+	if err = cdc.Unmarshal(bb, &raw); err != nil {
+		return
+	}
+	if raw == nil {
+		// an empty or "null" config file decodes to a nil map; treat it
+		// the same as an empty one rather than letting migrate panic on
+		// assignment into a nil map.
+		raw = map[string]interface{}{}
+	}
+	rawVersion, _ := raw["version"].(string)
+	if err = migrate(raw); err != nil {
+		return
+	}
+	if migratedVersion, _ := raw["version"].(string); migratedVersion != rawVersion {
+		// the config was upgraded in memory; persist the migrated form so
+		// subsequent loads do not need to migrate again.
+		if bb, err = cdc.Marshal(raw); err != nil {
+			return
+		}
+		if err = ioutil.WriteFile(filename, bb, 0644); err != nil {
+			return
+		}
+	}
+
 	errMsg := ""
-	errMsgHeader := "'func.yaml' config file is not valid:\n"
-	errMsgReg := regexp.MustCompile("not found in type .*")
+	errMsgHeader := fmt.Sprintf("'%s' config file is not valid:\n", filepath.Base(filename))
 
 	// Let's try to unmarshal the config file, any fields that are found
 	// in the data that do not have corresponding struct members, or mapping
 	// keys that are duplicates, will result in an error.
-	err = yaml.UnmarshalStrict(bb, &c)
+	err = cdc.UnmarshalStrict(bb, &c)
 	if err != nil {
-		errMsg = err.Error()
-
-		if strings.HasPrefix(errMsg, "yaml: unmarshal errors:") {
-			errMsg = errMsgReg.ReplaceAllString(errMsg, "is not valid")
-			errMsg = strings.Replace(errMsg, "yaml: unmarshal errors:\n", errMsgHeader, 1)
-		} else if strings.HasPrefix(errMsg, "yaml:") {
-			errMsg = errMsgReg.ReplaceAllString(errMsg, "is not valid")
-			errMsg = strings.Replace(errMsg, "yaml: ", errMsgHeader+"  ", 1)
-		}
+		errMsg = errMsgHeader + "  " + cdc.FormatError(err)
 	}
 
 	// Let's check that all entries in `volumes` and `envs` contain all required fields
@@ -119,6 +558,169 @@ func newConfig(root string) (c config, err error) {
 	return
 }
 
+// envOverridePrefix is the prefix used for environment-variable config
+// overrides, e.g. FUNC_NAMESPACE, FUNC_IMAGE, FUNC_BUILDER.  A map field
+// such as BuilderMap is addressable per-key as FUNC_<FIELD>_<KEY>, e.g.
+// FUNC_BUILDERMAP_PACK.
+const envOverridePrefix = "FUNC_"
+
+// newConfigLayered composes a Function's config from, in order of
+// increasing precedence: the base config file for root (see newConfig),
+// an optional environment-specific overlay func.<env>.yaml alongside it,
+// and finally environment variables of the form FUNC_<FIELD>.  env is
+// typically the value of FUNC_ENV; lookup is injected (usually
+// os.Getenv) so the override source can be controlled by callers.
+func newConfigLayered(root string, env string, lookup func(string) string) (c config, err error) {
+	if c, err = newConfig(root); err != nil {
+		return
+	}
+
+	if env != "" {
+		var raw map[string]interface{}
+		if raw, err = readOverlay(root, env); err != nil {
+			err = fmt.Errorf("func.%s.yaml overlay: %w", env, err)
+			return
+		}
+		if raw != nil {
+			if err = mergeConfig(&c, raw); err != nil {
+				err = fmt.Errorf("func.%s.yaml overlay: %w", env, err)
+				return
+			}
+		}
+	}
+
+	if err = applyEnvOverrides(&c, lookup); err != nil {
+		err = fmt.Errorf("environment variable overrides: %w", err)
+	}
+	return
+}
+
+// readOverlay loads func.<env>.yaml from root, if present, returning its
+// decoded form as a raw map (so mergeConfig can tell which fields were
+// actually set in the overlay, as opposed to left at their zero value).
+// It is also strictly unmarshalled into a throwaway config so that a
+// typo'd field in the overlay is caught independently of the base layer.
+func readOverlay(root, env string) (raw map[string]interface{}, err error) {
+	filename := filepath.Join(root, fmt.Sprintf("func.%s.yaml", env))
+	if _, err = os.Stat(filename); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	bb, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(bb, &raw); err != nil {
+		return nil, err
+	}
+	var overlay config
+	if err = yaml.UnmarshalStrict(bb, &overlay); err != nil {
+		return nil, errors.New(yamlCodec{}.FormatError(err))
+	}
+	return raw, nil
+}
+
+// mergeConfig overlays onto dst every field actually present in raw,
+// matching raw's keys to dst's fields via their yaml struct tags.  Map
+// fields (BuilderMap, Annotations) are merged key-by-key rather than
+// replaced wholesale.
+func mergeConfig(dst *config, raw map[string]interface{}) error {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		rawValue, present := raw[tag]
+		if !present {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Map {
+			rawMap, ok := rawValue.(map[interface{}]interface{})
+			if !ok {
+				return fmt.Errorf("field '%s' has the wrong type", tag)
+			}
+			dstMap := v.Field(i)
+			if dstMap.IsNil() {
+				dstMap.Set(reflect.MakeMap(field.Type))
+			}
+			for k, val := range rawMap {
+				dstMap.SetMapIndex(reflect.ValueOf(fmt.Sprintf("%v", k)), reflect.ValueOf(fmt.Sprintf("%v", val)))
+			}
+			continue
+		}
+
+		bb, err := yaml.Marshal(rawValue)
+		if err != nil {
+			return fmt.Errorf("field '%s': %w", tag, err)
+		}
+		fv := reflect.New(field.Type)
+		if err := yaml.Unmarshal(bb, fv.Interface()); err != nil {
+			return fmt.Errorf("field '%s': %w", tag, err)
+		}
+		v.Field(i).Set(fv.Elem())
+	}
+	return nil
+}
+
+// envOverrideSkipFields lists the config fields FUNC_<FIELD> must never
+// touch: Version is this file's own schema version, and ImageDigest is an
+// immutable build output, so neither is something an operator overrides.
+var envOverrideSkipFields = map[string]bool{
+	"version":     true,
+	"imageDigest": true,
+}
+
+// regDNS1123Label matches a Kubernetes DNS-1123 label: lowercase
+// alphanumeric characters or '-', starting and ending with an
+// alphanumeric character.  Name and Namespace become Kubernetes object
+// names, so overrides of either are validated against it.
+var regDNS1123Label = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// applyEnvOverrides is the final, highest-precedence layer: for every
+// overridable string field of config (see envOverrideSkipFields), if
+// lookup(FUNC_<FIELD>) returns a non-empty value it replaces the field
+// outright.  For map fields, each existing key is individually
+// overridable via FUNC_<FIELD>_<KEY>.
+func applyEnvOverrides(c *config, lookup func(string) string) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" || envOverrideSkipFields[tag] {
+			continue
+		}
+		name := envOverridePrefix + strings.ToUpper(tag)
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			val := lookup(name)
+			if val == "" {
+				continue
+			}
+			if (tag == "name" || tag == "namespace") && !regDNS1123Label.MatchString(val) {
+				return fmt.Errorf("%s='%s' is not a valid DNS-1123 label", name, val)
+			}
+			v.Field(i).SetString(val)
+		case reflect.Map:
+			dstMap := v.Field(i)
+			prefix := name + "_"
+			for _, key := range dstMap.MapKeys() {
+				if val := lookup(prefix + strings.ToUpper(key.String())); val != "" {
+					dstMap.SetMapIndex(key, reflect.ValueOf(val))
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // fromConfig returns a Function populated from config.
 // Note that config does not include ancillary fields not serialized, such as Root.
 func fromConfig(c config) (f Function) {
@@ -140,6 +742,7 @@ func fromConfig(c config) (f Function) {
 // toConfig serializes a Function to a config object.
 func toConfig(f Function) config {
 	return config{
+		Version:     CurrentConfigVersion,
 		Name:        f.Name,
 		Namespace:   f.Namespace,
 		Runtime:     f.Runtime,
@@ -154,12 +757,14 @@ func toConfig(f Function) config {
 	}
 }
 
-// writeConfig for the given Function out to disk at root.
+// writeConfig for the given Function out to disk at root, in whichever of
+// func.yaml/func.json/func.toml is already in use there (func.yaml, or the
+// format named by FUNC_CONFIG_FORMAT, if none exists yet).
 func writeConfig(f Function) (err error) {
-	path := filepath.Join(f.Root, ConfigFile)
+	path, format := resolveConfigFile(f.Root)
 	c := toConfig(f)
 	var bb []byte
-	if bb, err = yaml.Marshal(&c); err != nil {
+	if bb, err = codecs[format].Marshal(&c); err != nil {
 		return
 	}
 	return ioutil.WriteFile(path, bb, 0644)
@@ -173,28 +778,169 @@ func writeConfig(f Function) (err error) {
 // 	 path: /etc/secret-volume
 // - configMap: example-configMap              	# mount ConfigMap as Volume
 // 	 path: /etc/configMap-volume
+// - persistentVolumeClaim: example-pvc          	# mount a PersistentVolumeClaim as Volume
+// 	 path: /etc/pvc-volume
+// - emptyDir: {}                               	# mount a node-local scratch Volume
+// 	 path: /etc/emptydir-volume
+//
+// A Secret or ConfigMap source may additionally list `items` to project
+// individual keys to specific file paths instead of mounting every key.
 func validateVolumes(volumes Volumes) (errors []string) {
 
 	for i, vol := range volumes {
-		if vol.Secret != nil && vol.ConfigMap != nil {
-			errors = append(errors, fmt.Sprintf("volume entry #%d is not properly set, both secret '%s' and configMap '%s' can not be set at the same time",
-				i, *vol.Secret, *vol.ConfigMap))
-		} else if vol.Path == nil && vol.Secret == nil && vol.ConfigMap == nil {
+		// exactly one of secret/configMap/persistentVolumeClaim/emptyDir
+		// may be set as the volume's source.
+		var sourceNames []string
+		if vol.Secret != nil {
+			sourceNames = append(sourceNames, fmt.Sprintf("secret '%s'", *vol.Secret))
+		}
+		if vol.ConfigMap != nil {
+			sourceNames = append(sourceNames, fmt.Sprintf("configMap '%s'", *vol.ConfigMap))
+		}
+		if vol.PersistentVolumeClaim != nil {
+			sourceNames = append(sourceNames, fmt.Sprintf("persistentVolumeClaim '%s'", *vol.PersistentVolumeClaim))
+		}
+		if vol.EmptyDir != nil {
+			sourceNames = append(sourceNames, "emptyDir")
+		}
+
+		switch {
+		case len(sourceNames) > 1:
+			errors = append(errors, fmt.Sprintf("volume entry #%d is not properly set, only one of secret, configMap, persistentVolumeClaim or emptyDir can be set at the same time, but it has %s",
+				i, strings.Join(sourceNames, " and ")))
+		case len(sourceNames) == 0 && vol.Path == nil:
 			errors = append(errors, fmt.Sprintf("volume entry #%d is not properly set", i))
-		} else if vol.Path == nil {
-			if vol.Secret != nil {
-				errors = append(errors, fmt.Sprintf("volume entry #%d is missing path field, only secret '%s' is set", i, *vol.Secret))
-			} else if vol.ConfigMap != nil {
-				errors = append(errors, fmt.Sprintf("volume entry #%d is missing path field, only configMap '%s' is set", i, *vol.ConfigMap))
+		case len(sourceNames) == 0:
+			errors = append(errors, fmt.Sprintf("volume entry #%d is missing secret, configMap, persistentVolumeClaim or emptyDir field, only path '%s' is set", i, *vol.Path))
+		case vol.Path == nil:
+			errors = append(errors, fmt.Sprintf("volume entry #%d is missing path field, only %s is set", i, sourceNames[0]))
+		}
+
+		if len(vol.Items) > 0 && (vol.PersistentVolumeClaim != nil || vol.EmptyDir != nil) {
+			errors = append(errors, fmt.Sprintf("volume entry #%d has items set, but items is only applicable to secret or configMap sources", i))
+		}
+
+		for j, item := range vol.Items {
+			if item.Path == "" {
+				errors = append(errors, fmt.Sprintf("volume entry #%d, item #%d is missing path field", i, j))
+			} else if filepath.IsAbs(item.Path) || strings.Contains(filepath.ToSlash(item.Path), "..") {
+				errors = append(errors, fmt.Sprintf("volume entry #%d, item #%d has invalid path '%s', it must be relative and must not contain '..'", i, j, item.Path))
+			}
+			if item.Mode != nil && (*item.Mode < 0 || *item.Mode > 0777) {
+				errors = append(errors, fmt.Sprintf("volume entry #%d, item #%d has invalid mode '%o', it must be between 0 and 0777", i, j, *item.Mode))
 			}
-		} else if vol.Path != nil && vol.Secret == nil && vol.ConfigMap == nil {
-			errors = append(errors, fmt.Sprintf("volume entry #%d is missing secret or configMap field, only path '%s' is set", i, *vol.Path))
 		}
 	}
 
 	return
 }
 
+// EnvSource identifies which kind of value an Env.Value string resolves
+// to, as classified by parseEnvValue.
+type EnvSource int
+
+const (
+	EnvSourcePlain EnvSource = iota
+	EnvSourceLocalEnv
+	EnvSourceSecretKey
+	EnvSourceSecretAll
+	EnvSourceConfigMapKey
+	EnvSourceConfigMapAll
+	EnvSourceFieldRef
+	EnvSourceResourceFieldRef
+)
+
+// there could be '-' char in the secret/configMap name, but not in the key
+var (
+	regWholeSecret       = regexp.MustCompile(`^{{\s*secret\.(?:\w|['-]\w)+\s*}}$`)
+	regKeyFromSecret     = regexp.MustCompile(`^{{\s*secret\.(?:\w|['-]\w)+\.\w+\s*}}$`)
+	regWholeConfigMap    = regexp.MustCompile(`^{{\s*configMap\.(?:\w|['-]\w)+\s*}}$`)
+	regKeyFromConfigMap  = regexp.MustCompile(`^{{\s*configMap\.(?:\w|['-]\w)+\.\w+\s*}}$`)
+	regLocalEnv          = regexp.MustCompile(`^{{\s*env\.(\w+)\s*}}$`)
+	regFieldRef          = regexp.MustCompile(`^{{\s*field\.(metadata\.name|metadata\.namespace|spec\.nodeName|spec\.serviceAccountName|status\.hostIP|status\.podIP|status\.podIPs)\s*}}$`)
+	regFieldRefMapKey    = regexp.MustCompile(`^{{\s*field\.metadata\.(labels|annotations)\['[\w./-]+'\]\s*}}$`)
+	regResourceFieldRef  = regexp.MustCompile(`^{{\s*resource\.(?:([\w-]+)\.)?(limits|requests)\.([\w.\-/]+)\s*}}$`)
+)
+
+// envValueGrammar is the full set of forms allowed in Env.Value, used to
+// build error messages so users discover all of the supported forms.
+const envValueGrammar = "'{{ env.MY_ENV }}', '{{ secret.secretName.key }}', '{{ configMap.configMapName.key }}', " +
+	"'{{ field.metadata.name }}', '{{ field.metadata.namespace }}', '{{ field.metadata.labels['key'] }}', " +
+	"'{{ field.metadata.annotations['key'] }}', '{{ field.spec.nodeName }}', '{{ field.spec.serviceAccountName }}', " +
+	"'{{ field.status.hostIP }}', '{{ field.status.podIP }}', '{{ field.status.podIPs }}', " +
+	"'{{ resource.limits.cpu }}' or '{{ resource.<container>.requests.memory }}'"
+
+// parseEnvValue classifies value (the contents of an Env.Value field) and
+// returns which EnvSource it resolves to.  A value that does not begin
+// with "{{" is EnvSourcePlain, a literal passed through unchanged.
+func parseEnvValue(value string) (EnvSource, error) {
+	if !strings.HasPrefix(value, "{{") {
+		return EnvSourcePlain, nil
+	}
+	switch {
+	case regLocalEnv.MatchString(value):
+		return EnvSourceLocalEnv, nil
+	case regKeyFromSecret.MatchString(value):
+		return EnvSourceSecretKey, nil
+	case regWholeSecret.MatchString(value):
+		return EnvSourceSecretAll, nil
+	case regKeyFromConfigMap.MatchString(value):
+		return EnvSourceConfigMapKey, nil
+	case regWholeConfigMap.MatchString(value):
+		return EnvSourceConfigMapAll, nil
+	case regFieldRef.MatchString(value), regFieldRefMapKey.MatchString(value):
+		return EnvSourceFieldRef, nil
+	case regResourceFieldRef.MatchString(value):
+		return EnvSourceResourceFieldRef, nil
+	default:
+		return 0, fmt.Errorf("value '%s' is not valid, allowed is only %s", value, envValueGrammar)
+	}
+}
+
+// values returns env's fallback sources as a normalized list: Values
+// itself if populated, otherwise Value as a single-element list, so that
+// callers constructed without going through Env's custom unmarshalling
+// (e.g. decoded from func.toml, which has no union type for `value`)
+// still see a consistent view.
+func (e Env) values() []string {
+	if len(e.Values) > 0 {
+		return e.Values
+	}
+	if e.Value != nil {
+		return []string{*e.Value}
+	}
+	return nil
+}
+
+// ResolveEnv walks env's fallback sources in order and returns the first
+// one that resolves to a non-empty string. Local-env references
+// ({{ env.MY_ENV }}) are resolved via lookup; plain literals are returned
+// as-is.  Secret, configMap and downward-API/resource references cannot
+// be resolved without cluster access, so they are returned unresolved
+// (verbatim) for the deployer to materialize, and end the fallback walk.
+func ResolveEnv(env Env, lookup func(string) string) (string, bool) {
+	for _, value := range env.values() {
+		source, err := parseEnvValue(value)
+		if err != nil {
+			continue
+		}
+		switch source {
+		case EnvSourcePlain:
+			if value != "" {
+				return value, true
+			}
+		case EnvSourceLocalEnv:
+			name := regLocalEnv.FindStringSubmatch(value)[1]
+			if resolved := lookup(name); resolved != "" {
+				return resolved, true
+			}
+		default:
+			return value, true
+		}
+	}
+	return "", false
+}
+
 // ValidateEnvs checks that input Envs are correct and contain all necessary fields.
 // Returns array of error messages, empty if none
 //
@@ -209,39 +955,39 @@ func validateVolumes(volumes Volumes) (errors []string) {
 // - name: EXAMPLE4
 //   value: {{ configMap.configMapName.key }}   	# ENV from a key in configMap
 // - value: {{ configMap.configMapName }}          	# all key-pair values from configMap are set as ENV
+// - name: EXAMPLE5
+//   value: {{ field.metadata.name }}   			# ENV from a Kubernetes downward-API field
+// - name: EXAMPLE6
+//   value: {{ resource.limits.cpu }}   			# ENV from a container resource limit/request
+// - name: EXAMPLE7                					# ordered fallback sources, first to resolve wins
+//   value: ["{{ env.PRIMARY }}", "{{ env.FALLBACK }}", "literal-default"]
 func ValidateEnvs(envs Envs) (errors []string) {
 
-	// there could be '-' char in the secret/configMap name, but not in the key
-	regWholeSecret := regexp.MustCompile(`^{{\s*secret\.(?:\w|['-]\w)+\s*}}$`)
-	regKeyFromSecret := regexp.MustCompile(`^{{\s*secret\.(?:\w|['-]\w)+\.\w+\s*}}$`)
-	regWholeConfigMap := regexp.MustCompile(`^{{\s*configMap\.(?:\w|['-]\w)+\s*}}$`)
-	regKeyFromConfigMap := regexp.MustCompile(`^{{\s*configMap\.(?:\w|['-]\w)+\.\w+\s*}}$`)
-	regLocalEnv := regexp.MustCompile(`^{{\s*env\.(\w+)\s*}}$`)
-
 	for i, env := range envs {
-		if env.Name == nil && env.Value == nil {
+		values := env.values()
+		if env.Name == nil && len(values) == 0 {
 			errors = append(errors, fmt.Sprintf("env entry #%d is not properly set", i))
-		} else if env.Value == nil {
+		} else if len(values) == 0 {
 			errors = append(errors, fmt.Sprintf("env entry #%d is missing value field, only name '%s' is set", i, *env.Name))
 		} else if env.Name == nil {
 			// all key-pair values from secret are set as ENV; {{ secret.secretName }} or {{ configMap.configMapName }}
-			if !regWholeSecret.MatchString(*env.Value) && !regWholeConfigMap.MatchString(*env.Value) {
+			if len(values) > 1 {
+				errors = append(errors, fmt.Sprintf("env entry #%d has invalid value field set, a list of fallback values is only allowed when name is set", i))
+			} else if !regWholeSecret.MatchString(values[0]) && !regWholeConfigMap.MatchString(values[0]) {
 				errors = append(errors, fmt.Sprintf("env entry #%d has invalid value field set, it has '%s', but allowed is only '{{ secret.secretName }}' or '{{ configMap.configMapName }}'",
-				 i, *env.Value))
+				 i, values[0]))
 			}
 		} else {
-			if strings.HasPrefix(*env.Value, "{{") {
-				// ENV from the local ENV var; {{ env.MY_ENV }}
-				// or
-				// ENV from a key in secret/configMap;  {{ secret.secretName.key }} or {{ configMap.configMapName.key }}
-				if !regLocalEnv.MatchString(*env.Value) && !regKeyFromSecret.MatchString(*env.Value) && !regKeyFromConfigMap.MatchString(*env.Value) {
-					errors = append(errors,
-						fmt.Sprintf(
-							"env entry #%d with name '%s' has invalid value field set, it has '%s', but allowed is only '{{ env.MY_ENV }}', '{{ secret.secretName.key }}' or '{{ configMap.configMapName.key }}'",
-							i, *env.Name, *env.Value))
+			for _, value := range values {
+				if strings.HasPrefix(value, "{{") {
+					// whole-secret/configMap values are only valid on entries without a name
+					if source, err := parseEnvValue(value); err != nil || source == EnvSourceSecretAll || source == EnvSourceConfigMapAll {
+						errors = append(errors,
+							fmt.Sprintf("env entry #%d with name '%s' has invalid value field set, it has '%s', but allowed is only %s",
+								i, *env.Name, value, envValueGrammar))
+					}
 				}
 			}
-
 		}
 	}
 
@@ -0,0 +1,376 @@
+package function
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func strPtr(s string) *string { return &s }
+func boolPtr(b bool) *bool    { return &b }
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestParseEnvValue(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  EnvSource
+	}{
+		{"plain literal", "a-literal-value", EnvSourcePlain},
+		{"local env", "{{ env.MY_ENV }}", EnvSourceLocalEnv},
+		{"secret key", "{{ secret.my-secret.key }}", EnvSourceSecretKey},
+		{"whole secret", "{{ secret.my-secret }}", EnvSourceSecretAll},
+		{"configMap key", "{{ configMap.my-map.key }}", EnvSourceConfigMapKey},
+		{"whole configMap", "{{ configMap.my-map }}", EnvSourceConfigMapAll},
+		{"field metadata.name", "{{ field.metadata.name }}", EnvSourceFieldRef},
+		{"field metadata.labels", "{{ field.metadata.labels['app'] }}", EnvSourceFieldRef},
+		{"field status.podIP", "{{ field.status.podIP }}", EnvSourceFieldRef},
+		{"resource limits", "{{ resource.limits.cpu }}", EnvSourceResourceFieldRef},
+		{"resource with container", "{{ resource.worker.requests.memory }}", EnvSourceResourceFieldRef},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseEnvValue(c.value)
+			if err != nil {
+				t.Fatalf("parseEnvValue(%q) returned unexpected error: %v", c.value, err)
+			}
+			if got != c.want {
+				t.Errorf("parseEnvValue(%q) = %v, want %v", c.value, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvValueInvalid(t *testing.T) {
+	invalid := []string{
+		"{{ env. }}",
+		"{{ secret. }}",
+		"{{ field.metadata.bogus }}",
+		"{{ resource.cpu }}",
+	}
+	for _, value := range invalid {
+		if _, err := parseEnvValue(value); err == nil {
+			t.Errorf("parseEnvValue(%q) expected an error, got nil", value)
+		}
+	}
+}
+
+func TestValidateVolumesSourceExclusivity(t *testing.T) {
+	volumes := Volumes{
+		{Secret: strPtr("s1"), ConfigMap: strPtr("c1"), Path: strPtr("/etc/s1")},
+		{Path: strPtr("/etc/none")},
+		{Secret: strPtr("s1")},
+	}
+	errs := validateVolumes(volumes)
+	if len(errs) != 3 {
+		t.Fatalf("validateVolumes() returned %d errors, want 3: %v", len(errs), errs)
+	}
+}
+
+func TestValidateVolumesItemsRequireSecretOrConfigMap(t *testing.T) {
+	volumes := Volumes{
+		{
+			PersistentVolumeClaim: strPtr("my-pvc"),
+			Path:                  strPtr("/etc/pvc"),
+			Items:                 []KeyToPath{{Key: "k", Path: "p"}},
+		},
+		{
+			EmptyDir: &EmptyDirOptions{},
+			Path:     strPtr("/etc/scratch"),
+			Items:    []KeyToPath{{Key: "k", Path: "p"}},
+		},
+		{
+			Secret: strPtr("my-secret"),
+			Path:   strPtr("/etc/secret"),
+			Items:  []KeyToPath{{Key: "k", Path: "p"}},
+		},
+	}
+	errs := validateVolumes(volumes)
+	if len(errs) != 2 {
+		t.Fatalf("validateVolumes() returned %d errors, want 2 (entries #0 and #1): %v", len(errs), errs)
+	}
+}
+
+func TestValidateVolumesItemPathAndMode(t *testing.T) {
+	badMode := int32(01000)
+	volumes := Volumes{
+		{
+			Secret: strPtr("my-secret"),
+			Path:   strPtr("/etc/secret"),
+			Items: []KeyToPath{
+				{Key: "k1", Path: "../escape"},
+				{Key: "k2", Path: "ok", Mode: &badMode},
+				{Key: "k3", Path: ""},
+			},
+		},
+	}
+	errs := validateVolumes(volumes)
+	if len(errs) != 3 {
+		t.Fatalf("validateVolumes() returned %d errors, want 3: %v", len(errs), errs)
+	}
+}
+
+func TestApplyEnvOverridesSkipsVersionAndImageDigest(t *testing.T) {
+	c := config{Version: CurrentConfigVersion, ImageDigest: "sha256:original"}
+	lookup := func(name string) string {
+		switch name {
+		case "FUNC_VERSION":
+			return "0.0"
+		case "FUNC_IMAGEDIGEST":
+			return "sha256:tampered"
+		}
+		return ""
+	}
+	if err := applyEnvOverrides(&c, lookup); err != nil {
+		t.Fatalf("applyEnvOverrides() returned unexpected error: %v", err)
+	}
+	if c.Version != CurrentConfigVersion {
+		t.Errorf("Version = %q, want unchanged %q", c.Version, CurrentConfigVersion)
+	}
+	if c.ImageDigest != "sha256:original" {
+		t.Errorf("ImageDigest = %q, want unchanged %q", c.ImageDigest, "sha256:original")
+	}
+}
+
+func TestApplyEnvOverridesRejectsInvalidNamespace(t *testing.T) {
+	c := config{Namespace: "valid-ns"}
+	lookup := func(name string) string {
+		if name == "FUNC_NAMESPACE" {
+			return "Not_A_Valid_Namespace!"
+		}
+		return ""
+	}
+	err := applyEnvOverrides(&c, lookup)
+	if err == nil {
+		t.Fatal("applyEnvOverrides() expected an error for an invalid FUNC_NAMESPACE, got nil")
+	}
+	if c.Namespace != "valid-ns" {
+		t.Errorf("Namespace = %q, want unchanged %q after a rejected override", c.Namespace, "valid-ns")
+	}
+}
+
+func TestApplyEnvOverridesBuilderMapByKey(t *testing.T) {
+	c := config{BuilderMap: map[string]string{"pack": "original"}}
+	lookup := func(name string) string {
+		if name == "FUNC_BUILDERMAP_PACK" {
+			return "overridden"
+		}
+		return ""
+	}
+	if err := applyEnvOverrides(&c, lookup); err != nil {
+		t.Fatalf("applyEnvOverrides() returned unexpected error: %v", err)
+	}
+	if c.BuilderMap["pack"] != "overridden" {
+		t.Errorf("BuilderMap[pack] = %q, want %q", c.BuilderMap["pack"], "overridden")
+	}
+}
+
+// TestNewConfigLayeredPrecedence exercises the full base/overlay/env-var
+// precedence order newConfigLayered composes: the env var override wins
+// over the overlay, the overlay wins over the base, and base values the
+// overlay didn't itself set are kept.
+func TestNewConfigLayeredPrecedence(t *testing.T) {
+	root, err := os.MkdirTemp("", "func-config-layered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	base := "version: \"1.0\"\nname: myfunc\nnamespace: base-ns\nimage: base-image\nbuilder: pack\n"
+	if err := os.WriteFile(filepath.Join(root, "func.yaml"), []byte(base), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overlay := "namespace: staging-ns\nimage: staging-image\n"
+	if err := os.WriteFile(filepath.Join(root, "func.staging.yaml"), []byte(overlay), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(name string) string {
+		if name == "FUNC_IMAGE" {
+			return "env-image"
+		}
+		return ""
+	}
+
+	c, err := newConfigLayered(root, "staging", lookup)
+	if err != nil {
+		t.Fatalf("newConfigLayered() returned unexpected error: %v", err)
+	}
+	if c.Namespace != "staging-ns" {
+		t.Errorf("Namespace = %q, want overlay value %q", c.Namespace, "staging-ns")
+	}
+	if c.Image != "env-image" {
+		t.Errorf("Image = %q, want env-var override %q", c.Image, "env-image")
+	}
+	if c.Builder != "pack" {
+		t.Errorf("Builder = %q, want base value %q (untouched by overlay or env)", c.Builder, "pack")
+	}
+}
+
+func TestMigrateAppliesVersion0ToCurrent(t *testing.T) {
+	raw := map[string]interface{}{"name": "myfunc"}
+	if err := migrate(raw); err != nil {
+		t.Fatalf("migrate() returned unexpected error: %v", err)
+	}
+	if raw["version"] != CurrentConfigVersion {
+		t.Errorf("version = %v, want %v", raw["version"], CurrentConfigVersion)
+	}
+}
+
+func TestMigrateNoopAtCurrentVersion(t *testing.T) {
+	raw := map[string]interface{}{"name": "myfunc", "version": CurrentConfigVersion}
+	if err := migrate(raw); err != nil {
+		t.Fatalf("migrate() returned unexpected error: %v", err)
+	}
+	if raw["version"] != CurrentConfigVersion {
+		t.Errorf("version = %v, want unchanged %v", raw["version"], CurrentConfigVersion)
+	}
+}
+
+func TestMigrateUnknownVersionErrors(t *testing.T) {
+	raw := map[string]interface{}{"version": "99.0"}
+	if err := migrate(raw); err == nil {
+		t.Error("migrate() expected an error for an unmigratable version, got nil")
+	}
+}
+
+func TestNewConfigMigratesAndPersistsMissingVersion(t *testing.T) {
+	root, err := os.MkdirTemp("", "func-config-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	unversioned := "name: myfunc\nnamespace: default\n"
+	path := filepath.Join(root, "func.yaml")
+	if err := os.WriteFile(path, []byte(unversioned), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newConfig(root)
+	if err != nil {
+		t.Fatalf("newConfig() returned unexpected error: %v", err)
+	}
+	if c.Version != CurrentConfigVersion {
+		t.Errorf("Version = %q, want %q", c.Version, CurrentConfigVersion)
+	}
+
+	persisted, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(persisted, &raw); err != nil {
+		t.Fatalf("persisted func.yaml does not parse as YAML: %v", err)
+	}
+	if raw["version"] != CurrentConfigVersion {
+		t.Errorf("migrated version was not persisted back to disk: got %v, want %q in %s", raw["version"], CurrentConfigVersion, persisted)
+	}
+}
+
+// TestNewConfigEmptyFileDoesNotPanic covers an empty or "null" func.yaml
+// (e.g. right after `touch func.yaml`): it must decode to an otherwise empty
+// Config migrated to CurrentConfigVersion, not panic when migrate assigns
+// into what would otherwise be a nil map.
+func TestNewConfigEmptyFileDoesNotPanic(t *testing.T) {
+	root, err := os.MkdirTemp("", "func-config-empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "func.yaml")
+	if err := os.WriteFile(path, []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newConfig(root)
+	if err != nil {
+		t.Fatalf("newConfig() returned unexpected error: %v", err)
+	}
+	if c.Version != CurrentConfigVersion {
+		t.Errorf("Version = %q, want %q", c.Version, CurrentConfigVersion)
+	}
+}
+
+// TestConfigCodecRoundTrip marshals and unmarshals the same config through
+// each of the three supported encodings, asserting that Name, Namespace,
+// Image and every Env's Values (including the list-form fallback chain)
+// survive the round trip.
+func TestConfigCodecRoundTrip(t *testing.T) {
+	want := config{
+		Version:     CurrentConfigVersion,
+		Name:        "myfunc",
+		Namespace:   "default",
+		Runtime:     "go",
+		Image:       "example.com/myfunc:latest",
+		Builder:     "pack",
+		BuilderMap:  map[string]string{"pack": "ghcr.io/builder"},
+		Annotations: map[string]string{"team": "serverless"},
+		Volumes: Volumes{
+			{Secret: strPtr("my-secret"), Path: strPtr("/etc/secret")},
+		},
+		Envs: Envs{
+			{Name: strPtr("SINGLE"), Value: strPtr("literal"), Values: []string{"literal"}},
+			{
+				Name:   strPtr("FALLBACK"),
+				Value:  strPtr("{{ env.PRIMARY }}"),
+				Values: []string{"{{ env.PRIMARY }}", "{{ secret.s.key }}", "default"},
+			},
+		},
+	}
+
+	for format := range codecs {
+		t.Run(format, func(t *testing.T) {
+			cdc := codecs[format]
+			bb, err := cdc.Marshal(&want)
+			if err != nil {
+				t.Fatalf("%s Marshal() returned unexpected error: %v", format, err)
+			}
+			var got config
+			if err := cdc.UnmarshalStrict(bb, &got); err != nil {
+				t.Fatalf("%s UnmarshalStrict() returned unexpected error: %v", format, err)
+			}
+			if got.Name != want.Name || got.Namespace != want.Namespace || got.Image != want.Image {
+				t.Errorf("%s round trip changed scalar fields: got %+v, want %+v", format, got, want)
+			}
+			if len(got.Envs) != len(want.Envs) {
+				t.Fatalf("%s round trip: got %d envs, want %d", format, len(got.Envs), len(want.Envs))
+			}
+			for i := range want.Envs {
+				if !reflect.DeepEqual(got.Envs[i].Values, want.Envs[i].Values) {
+					t.Errorf("%s round trip envs[%d].Values = %v, want %v", format, i, got.Envs[i].Values, want.Envs[i].Values)
+				}
+			}
+		})
+	}
+}
+
+// TestEnvMarshalLegacyValueOnly covers an Env constructed with only the
+// legacy Value field set (Values left empty) — the shape produced by
+// callers not yet updated to populate Values, e.g. deploy_test.go's
+// fixtures.  MarshalYAML/MarshalJSON must still emit `value`.
+func TestEnvMarshalLegacyValueOnly(t *testing.T) {
+	env := Env{Name: strPtr("GREETING"), Value: strPtr("hello")}
+
+	yamlBytes, err := yaml.Marshal(env)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() returned unexpected error: %v", err)
+	}
+	if !strings.Contains(string(yamlBytes), "value: hello") {
+		t.Errorf("YAML marshal dropped the legacy Value field: %s", yamlBytes)
+	}
+
+	jsonBytes, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("json.Marshal() returned unexpected error: %v", err)
+	}
+	if !strings.Contains(string(jsonBytes), `"value":"hello"`) {
+		t.Errorf("JSON marshal dropped the legacy Value field: %s", jsonBytes)
+	}
+}
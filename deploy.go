@@ -0,0 +1,249 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// toEnvVars translates Envs into the []corev1.EnvVar and
+// []corev1.EnvFromSource a Knative Service's container spec needs.  Each
+// entry's ordered fallback sources (see Env.values) are walked via
+// ResolveEnv: literals and local-env references are resolved immediately
+// using lookup, while the first remaining secret/configMap/downward-API/
+// resource reference short-circuits the walk and is translated to its
+// typed source below.  Entries with a Name become EnvVar (literal,
+// SecretKeyRef, ConfigMapKeyRef, FieldRef or ResourceFieldRef depending on
+// the resolved value's EnvSource); entries without a Name (`value:
+// {{ secret.name }}` or `{{ configMap.name }}`) pull every key of the
+// referenced Secret or ConfigMap in as EnvFromSource.
+func toEnvVars(envs Envs, lookup func(string) string) (vars []corev1.EnvVar, from []corev1.EnvFromSource, err error) {
+	for i, env := range envs {
+		value, ok := ResolveEnv(env, lookup)
+		if !ok {
+			return nil, nil, fmt.Errorf("envs entry #%d: no source resolved (local env var unset and no further fallback)", i)
+		}
+
+		if env.Name == nil {
+			f, ferr := toEnvFromSource(value)
+			if ferr != nil {
+				return nil, nil, fmt.Errorf("envs entry #%d: %w", i, ferr)
+			}
+			from = append(from, f)
+			continue
+		}
+
+		v, verr := toEnvVar(*env.Name, value)
+		if verr != nil {
+			return nil, nil, fmt.Errorf("envs entry #%d: %w", i, verr)
+		}
+		vars = append(vars, v)
+	}
+	return
+}
+
+// toEnvVar builds the corev1.EnvVar for a single name/value pair,
+// choosing a literal Value or a typed ValueFrom source depending on how
+// parseEnvValue classifies value.
+func toEnvVar(name, value string) (corev1.EnvVar, error) {
+	source, err := parseEnvValue(value)
+	if err != nil {
+		return corev1.EnvVar{}, err
+	}
+	if source == EnvSourcePlain {
+		return corev1.EnvVar{Name: name, Value: value}, nil
+	}
+
+	envVarSource, err := toEnvVarSource(source, value)
+	if err != nil {
+		return corev1.EnvVar{}, err
+	}
+	return corev1.EnvVar{Name: name, ValueFrom: envVarSource}, nil
+}
+
+// toEnvVarSource translates value's deploy-time source (everything but a
+// plain literal) into the matching corev1.EnvVarSource.
+func toEnvVarSource(source EnvSource, value string) (*corev1.EnvVarSource, error) {
+	switch source {
+	case EnvSourceLocalEnv:
+		// toEnvVars resolves every local-env reference via ResolveEnv
+		// before calling toEnvVar, so a value still classified as
+		// EnvSourceLocalEnv here indicates a caller bypassed that
+		// resolution step.
+		return nil, fmt.Errorf("value '%s' must be resolved locally before deploy", value)
+	case EnvSourceSecretKey:
+		name, key := refKeyParts(value, "secret")
+		return &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  key,
+		}}, nil
+	case EnvSourceConfigMapKey:
+		name, key := refKeyParts(value, "configMap")
+		return &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  key,
+		}}, nil
+	case EnvSourceFieldRef:
+		return &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldRefPath(value)}}, nil
+	case EnvSourceResourceFieldRef:
+		container, resourceName := resourceFieldRefParts(value)
+		return &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{
+			ContainerName: container,
+			Resource:      resourceName,
+		}}, nil
+	default:
+		return nil, fmt.Errorf("value '%s' has no EnvVarSource translation", value)
+	}
+}
+
+// toEnvFromSource translates a whole-Secret/whole-ConfigMap value
+// (`{{ secret.name }}` or `{{ configMap.name }}`) into the matching
+// corev1.EnvFromSource.
+func toEnvFromSource(value string) (corev1.EnvFromSource, error) {
+	source, err := parseEnvValue(value)
+	if err != nil {
+		return corev1.EnvFromSource{}, err
+	}
+	switch source {
+	case EnvSourceSecretAll:
+		name := refAllName(value, "secret")
+		return corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		}}, nil
+	case EnvSourceConfigMapAll:
+		name := refAllName(value, "configMap")
+		return corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		}}, nil
+	default:
+		return corev1.EnvFromSource{}, fmt.Errorf("value '%s' is not a whole secret or configMap reference", value)
+	}
+}
+
+// trimBraces strips the surrounding "{{" "}}" and whitespace from a
+// value matched by one of the regexes in parseEnvValue's grammar.
+func trimBraces(value string) string {
+	inner := strings.TrimPrefix(value, "{{")
+	inner = strings.TrimSuffix(inner, "}}")
+	return strings.TrimSpace(inner)
+}
+
+// refKeyParts splits "{{ <kind>.name.key }}" into the referenced
+// object's name and key.
+func refKeyParts(value, kind string) (name, key string) {
+	inner := strings.TrimPrefix(trimBraces(value), kind+".")
+	parts := strings.SplitN(inner, ".", 2)
+	return parts[0], parts[1]
+}
+
+// refAllName extracts the referenced object's name from
+// "{{ <kind>.name }}".
+func refAllName(value, kind string) string {
+	return strings.TrimPrefix(trimBraces(value), kind+".")
+}
+
+// fieldRefPath extracts the downward-API field path (already in the form
+// corev1.ObjectFieldSelector.FieldPath expects, e.g. "metadata.name" or
+// "metadata.labels['key']") from "{{ field.<path> }}".
+func fieldRefPath(value string) string {
+	return strings.TrimPrefix(trimBraces(value), "field.")
+}
+
+// resourceFieldRefParts extracts the optional container name and the
+// limits/requests resource path from "{{ resource.<container>.limits.cpu }}"
+// or "{{ resource.limits.cpu }}".  It reuses regResourceFieldRef's own
+// submatches rather than re-splitting value on ".", since an extended
+// resource name (e.g. "requests.example.com/gpu") can itself contain dots
+// and would otherwise be misread as a container name.
+func resourceFieldRefParts(value string) (container, resourceName string) {
+	m := regResourceFieldRef.FindStringSubmatch(value)
+	return m[1], m[2] + "." + m[3]
+}
+
+// toVolumesAndMounts translates Volumes into the []corev1.Volume and
+// []corev1.VolumeMount a Knative Service's pod/container spec needs, one
+// pair per entry, named "vol-<index>" to keep the two lists aligned by
+// position.
+func toVolumesAndMounts(volumes Volumes) ([]corev1.Volume, []corev1.VolumeMount, error) {
+	vols := make([]corev1.Volume, 0, len(volumes))
+	mounts := make([]corev1.VolumeMount, 0, len(volumes))
+
+	for i, vol := range volumes {
+		name := fmt.Sprintf("vol-%d", i)
+
+		v := corev1.Volume{Name: name}
+		switch {
+		case vol.Secret != nil:
+			v.Secret = &corev1.SecretVolumeSource{
+				SecretName: *vol.Secret,
+				Items:      toKeyToPaths(vol.Items),
+			}
+		case vol.ConfigMap != nil:
+			v.ConfigMap = &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: *vol.ConfigMap},
+				Items:                toKeyToPaths(vol.Items),
+			}
+		case vol.PersistentVolumeClaim != nil:
+			pvc := &corev1.PersistentVolumeClaimVolumeSource{ClaimName: *vol.PersistentVolumeClaim}
+			if vol.ReadOnly != nil {
+				pvc.ReadOnly = *vol.ReadOnly
+			}
+			v.PersistentVolumeClaim = pvc
+		case vol.EmptyDir != nil:
+			emptyDir, err := toEmptyDirVolumeSource(vol.EmptyDir)
+			if err != nil {
+				return nil, nil, fmt.Errorf("volume entry #%d: %w", i, err)
+			}
+			v.EmptyDir = emptyDir
+		default:
+			return nil, nil, fmt.Errorf("volume entry #%d has no recognized source", i)
+		}
+		vols = append(vols, v)
+
+		mount := corev1.VolumeMount{Name: name}
+		if vol.Path != nil {
+			mount.MountPath = *vol.Path
+		}
+		if vol.ReadOnly != nil {
+			mount.ReadOnly = *vol.ReadOnly
+		}
+		if vol.SubPath != nil {
+			mount.SubPath = *vol.SubPath
+		}
+		mounts = append(mounts, mount)
+	}
+
+	return vols, mounts, nil
+}
+
+// toKeyToPaths translates a Volume's Items projection to the
+// corev1.KeyToPath form used by SecretVolumeSource/ConfigMapVolumeSource.
+func toKeyToPaths(items []KeyToPath) []corev1.KeyToPath {
+	if len(items) == 0 {
+		return nil
+	}
+	out := make([]corev1.KeyToPath, len(items))
+	for i, item := range items {
+		out[i] = corev1.KeyToPath{Key: item.Key, Path: item.Path, Mode: item.Mode}
+	}
+	return out
+}
+
+// toEmptyDirVolumeSource translates an EmptyDirOptions to the matching
+// corev1.EmptyDirVolumeSource.
+func toEmptyDirVolumeSource(opts *EmptyDirOptions) (*corev1.EmptyDirVolumeSource, error) {
+	source := &corev1.EmptyDirVolumeSource{}
+	if opts.Medium != nil {
+		source.Medium = corev1.StorageMedium(*opts.Medium)
+	}
+	if opts.SizeLimit != nil {
+		q, err := resource.ParseQuantity(*opts.SizeLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid emptyDir.sizeLimit '%s': %w", *opts.SizeLimit, err)
+		}
+		source.SizeLimit = &q
+	}
+	return source, nil
+}
@@ -0,0 +1,107 @@
+package function
+
+import (
+	"testing"
+)
+
+func TestToEnvVarsResolvesFallbackChain(t *testing.T) {
+	envs := Envs{
+		{Name: strPtr("GREETING"), Values: []string{"{{ env.UNSET_VAR }}", "{{ env.ALSO_UNSET }}", "hello"}},
+	}
+	lookup := func(string) string { return "" }
+
+	vars, from, err := toEnvVars(envs, lookup)
+	if err != nil {
+		t.Fatalf("toEnvVars() returned unexpected error: %v", err)
+	}
+	if len(from) != 0 {
+		t.Fatalf("toEnvVars() returned %d EnvFromSource, want 0", len(from))
+	}
+	if len(vars) != 1 || vars[0].Value != "hello" {
+		t.Fatalf("toEnvVars() = %+v, want a single literal EnvVar 'hello'", vars)
+	}
+}
+
+func TestToEnvVarsPrefersLocalEnvWhenSet(t *testing.T) {
+	envs := Envs{
+		{Name: strPtr("GREETING"), Values: []string{"{{ env.MY_GREETING }}", "fallback-default"}},
+	}
+	lookup := func(name string) string {
+		if name == "MY_GREETING" {
+			return "configured"
+		}
+		return ""
+	}
+
+	vars, _, err := toEnvVars(envs, lookup)
+	if err != nil {
+		t.Fatalf("toEnvVars() returned unexpected error: %v", err)
+	}
+	if len(vars) != 1 || vars[0].Value != "configured" {
+		t.Fatalf("toEnvVars() = %+v, want the resolved local-env value 'configured'", vars)
+	}
+}
+
+func TestToEnvVarsFallsThroughToSecretRef(t *testing.T) {
+	envs := Envs{
+		{Name: strPtr("API_KEY"), Values: []string{"{{ env.UNSET_VAR }}", "{{ secret.my-secret.key }}"}},
+	}
+	lookup := func(string) string { return "" }
+
+	vars, _, err := toEnvVars(envs, lookup)
+	if err != nil {
+		t.Fatalf("toEnvVars() returned unexpected error: %v", err)
+	}
+	if len(vars) != 1 || vars[0].ValueFrom == nil || vars[0].ValueFrom.SecretKeyRef == nil {
+		t.Fatalf("toEnvVars() = %+v, want a SecretKeyRef EnvVar", vars)
+	}
+	if vars[0].ValueFrom.SecretKeyRef.Name != "my-secret" || vars[0].ValueFrom.SecretKeyRef.Key != "key" {
+		t.Fatalf("toEnvVars() SecretKeyRef = %+v, want name=my-secret key=key", vars[0].ValueFrom.SecretKeyRef)
+	}
+}
+
+func TestToEnvVarsErrorsWhenNothingResolves(t *testing.T) {
+	envs := Envs{
+		{Name: strPtr("GREETING"), Values: []string{"{{ env.UNSET_VAR }}"}},
+	}
+	lookup := func(string) string { return "" }
+
+	if _, _, err := toEnvVars(envs, lookup); err == nil {
+		t.Fatal("toEnvVars() expected an error when no fallback source resolves, got nil")
+	}
+}
+
+func TestToEnvVarsWholeSecretAsEnvFromSource(t *testing.T) {
+	envs := Envs{
+		{Value: strPtr("{{ secret.my-secret }}")},
+	}
+	lookup := func(string) string { return "" }
+
+	_, from, err := toEnvVars(envs, lookup)
+	if err != nil {
+		t.Fatalf("toEnvVars() returned unexpected error: %v", err)
+	}
+	if len(from) != 1 || from[0].SecretRef == nil || from[0].SecretRef.Name != "my-secret" {
+		t.Fatalf("toEnvVars() from = %+v, want a single SecretEnvSource named my-secret", from)
+	}
+}
+
+func TestResourceFieldRefPartsExtendedResourceName(t *testing.T) {
+	container, resourceName := resourceFieldRefParts("{{ resource.requests.example.com/gpu }}")
+	if container != "" {
+		t.Errorf("container = %q, want empty (no container segment present)", container)
+	}
+	if resourceName != "requests.example.com/gpu" {
+		t.Errorf("resourceName = %q, want %q", resourceName, "requests.example.com/gpu")
+	}
+}
+
+func TestResourceFieldRefPartsWithContainer(t *testing.T) {
+	container, resourceName := resourceFieldRefParts("{{ resource.worker.limits.cpu }}")
+	if container != "worker" {
+		t.Errorf("container = %q, want %q", container, "worker")
+	}
+	if resourceName != "limits.cpu" {
+		t.Errorf("resourceName = %q, want %q", resourceName, "limits.cpu")
+	}
+}